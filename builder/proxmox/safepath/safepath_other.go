@@ -0,0 +1,49 @@
+//go:build !linux
+
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openBeneath resolves rel relative to root one component at a time,
+// lstat-ing each component so a symlink is rejected outright rather than
+// followed. There's no kernel-level atomicity guarantee here (unlike the
+// openat2-based Linux implementation): each check and the final os.Open
+// are separate calls on the full path, so a component replaced by a
+// symlink after it was checked is followed.
+func openBeneath(root, rel string) (*os.File, error) {
+	clean := filepath.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, "../") || filepath.IsAbs(clean) {
+		return nil, ErrEscapesRoot
+	}
+
+	dir := root
+	parts := strings.Split(clean, string(filepath.Separator))
+	for i, part := range parts {
+		full := filepath.Join(dir, part)
+
+		fi, err := os.Lstat(full)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil, errors.New("safepath: refusing to follow symlink at " + full)
+		}
+
+		if i < len(parts)-1 {
+			if !fi.IsDir() {
+				return nil, errors.New("safepath: " + full + " is not a directory")
+			}
+			dir = full
+			continue
+		}
+
+		return os.Open(full)
+	}
+
+	return nil, ErrEscapesRoot
+}
@@ -0,0 +1,81 @@
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen_InRoot(t *testing.T) {
+	root := t.TempDir()
+	want := []byte("hello")
+	if err := os.WriteFile(filepath.Join(root, "file.iso"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(root, "file.iso")
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %s", err)
+	}
+	defer f.Close()
+
+	got := make([]byte, len(want))
+	if _, err := f.Read(got); err != nil {
+		t.Fatalf("Read: unexpected error: %s", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("Read: got %q, want %q", got, want)
+	}
+}
+
+func TestOpen_RefusesDotDotEscape(t *testing.T) {
+	root := t.TempDir()
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rel := filepath.Join("..", filepath.Base(outside), "secret")
+	if _, err := Open(root, rel); !errors.Is(err, ErrEscapesRoot) {
+		t.Fatalf("Open(%q): got err %v, want ErrEscapesRoot", rel, err)
+	}
+}
+
+func TestOpen_RefusesSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+
+	outside := t.TempDir()
+	target := filepath.Join(outside, "secret")
+	if err := os.WriteFile(target, []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(root, "escape.iso")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(root, "escape.iso"); err == nil {
+		t.Fatal("Open: expected an error for a symlink escaping the trusted root, got nil")
+	}
+}
+
+func TestOpen_RefusesSymlinkEscapeThroughParentDir(t *testing.T) {
+	root := t.TempDir()
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(root, "dir")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(root, filepath.Join("dir", "secret")); err == nil {
+		t.Fatal("Open: expected an error for a symlinked intermediate directory escaping the trusted root, got nil")
+	}
+}
@@ -0,0 +1,71 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// filenameUnsafeChars matches anything but what's safe to drop straight
+// into a filename, so a VMName containing a path separator or ".." can't
+// steer the cloud-init snippet outside PackerCacheDir.
+var filenameUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitizeFilenameComponent(s string) string {
+	return filenameUnsafeChars.ReplaceAllString(s, "-")
+}
+
+// stepUploadCloudInitConfig renders the configured cloud-init user-data
+// to a snippet and uploads it to CloudInitStoragePool, so it can be
+// attached to the VM before boot without a separate manual pre-upload
+// step. It's a no-op when CloudInitUserData isn't set.
+type stepUploadCloudInitConfig struct {
+	TargetStoragePathKey string
+
+	inner *stepUploadFile
+}
+
+func (s *stepUploadCloudInitConfig) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	c := state.Get("config").(*Config)
+
+	if c.CloudInitUserData == "" {
+		return multistep.ActionContinue
+	}
+
+	filename := fmt.Sprintf("%s-cloud-init-user-data.yaml", sanitizeFilenameComponent(c.VMName))
+	localPath := filepath.Join(c.PackerCacheDir, filename)
+	if err := ioutil.WriteFile(localPath, []byte(c.CloudInitUserData), 0600); err != nil {
+		err = fmt.Errorf("writing cloud-init user-data snippet: %w", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	const sourcePathKey = "cloud_init_user_data_path"
+	state.Put(sourcePathKey, localPath)
+
+	s.inner = &stepUploadFile{
+		ShouldUpload:         true,
+		SourcePathKey:        sourcePathKey,
+		TrustedRoot:          c.PackerCacheDir,
+		Node:                 c.Node,
+		StoragePool:          c.CloudInitStoragePool,
+		ContentType:          "snippets",
+		Filename:             filename,
+		TargetStoragePathKey: s.TargetStoragePathKey,
+	}
+
+	return s.inner.Run(ctx, state)
+}
+
+func (s *stepUploadCloudInitConfig) Cleanup(state multistep.StateBag) {
+	if s.inner != nil {
+		s.inner.Cleanup(state)
+	}
+}
@@ -0,0 +1,298 @@
+package proxmox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer/builder/proxmox/safepath"
+	"github.com/hashicorp/packer/helper/multistep"
+	"github.com/hashicorp/packer/packer"
+)
+
+// defaultUploadChunkSize is how much of the source file is read into
+// memory between progress callbacks/checkpoints when a ChunkedUploader
+// is available.
+const defaultUploadChunkSize = 32 * 1024 * 1024
+
+// contentTypeVolumeDir maps a Proxmox storage content type to the
+// subdirectory its volume ids are rooted under, e.g.
+// "local:iso/debian.iso" or "local:snippets/user-data.yaml".
+var contentTypeVolumeDir = map[string]string{
+	"iso":      "iso",
+	"vztmpl":   "vztmpl",
+	"snippets": "snippets",
+	"backup":   "backup",
+	"import":   "import",
+}
+
+// stepUploadFile uploads a single local file to a Proxmox storage as a
+// given content type. It's the shared machinery behind stepUploadISO and
+// stepUploadCloudInitConfig; which file goes where and as what content
+// type is entirely up to the caller.
+type stepUploadFile struct {
+	ShouldUpload bool
+
+	// PreUploadedPath is used verbatim as the target storage path when
+	// ShouldUpload is false, i.e. the file already lives on the target
+	// storage and doesn't need pushing there.
+	PreUploadedPath string
+
+	// SourcePathKey is the multistep state key holding the path to the
+	// local file to upload. It must resolve under TrustedRoot.
+	SourcePathKey string
+	TrustedRoot   string
+
+	Node        string
+	StoragePool string
+	// ContentType is one of "iso", "vztmpl", "snippets", "backup" or
+	// "import". It's both passed to the upload call and used to pick
+	// the volume-id subdirectory.
+	ContentType string
+	Filename    string
+
+	TargetStoragePathKey string
+
+	resumeStatePath string
+}
+
+// uploader is the minimal single-shot upload capability every Proxmox
+// client provides.
+type uploader interface {
+	Upload(node string, storage string, contentType string, filename string, file io.Reader) error
+}
+
+// ChunkedUploader is implemented by clients that can resume an
+// interrupted upload instead of restarting it from byte zero, which
+// matters once ISOs run into the multi-gigabyte range. Implementations
+// are expected to issue the transfer as an HTTP Range/append-capable
+// request where the target storage supports it.
+type ChunkedUploader interface {
+	uploader
+
+	// UploadChunked uploads the bytes of r in [offset, size), reading
+	// chunkSize bytes at a time, and calls progress after every
+	// chunk with the cumulative number of bytes sent (offset included).
+	UploadChunked(ctx context.Context, node, storage, contentType, filename string, r io.ReaderAt, size int64, offset int64, chunkSize int64, progress func(uploaded int64)) error
+}
+
+// storageContentLister reports which content types a storage has been
+// configured to accept, so stepUploadFile can fail fast with a clear
+// error instead of letting Proxmox reject the upload after the fact.
+type storageContentLister interface {
+	GetStorageContentTypes(node, storage string) ([]string, error)
+}
+
+// uploadVerifier confirms that what landed on the storage actually
+// matches what was sent, via a HEAD request or the storage content
+// listing rather than trusting a successful Upload/UploadChunked call
+// on its own.
+type uploadVerifier interface {
+	VerifyUpload(node, storage, contentType, filename, expectedSHA256 string) error
+}
+
+var _ uploader = &proxmox.Client{}
+var _ storageContentLister = &proxmox.Client{}
+
+// uploadState is the resume checkpoint persisted under the trusted
+// download cache: enough to tell, on a retry, whether a partial upload
+// can be resumed (same file, same content) or must start over.
+type uploadState struct {
+	Filename      string `json:"filename"`
+	SHA256        string `json:"sha256"`
+	BytesUploaded int64  `json:"bytes_uploaded"`
+}
+
+func (s *stepUploadFile) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	client := state.Get("proxmoxClient").(uploader)
+
+	if !s.ShouldUpload {
+		state.Put(s.TargetStoragePathKey, s.PreUploadedPath)
+		return multistep.ActionContinue
+	}
+
+	p := state.Get(s.SourcePathKey).(string)
+	if p == "" {
+		err := fmt.Errorf("Path to %s file was empty", s.ContentType)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if err := validateStorageContentType(client, s.Node, s.StoragePool, s.ContentType); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	rel, err := filepath.Rel(s.TrustedRoot, p)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	r, err := safepath.Open(s.TrustedRoot, rel)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer r.Close()
+
+	fi, err := r.Stat()
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	size := fi.Size()
+
+	sum, err := sha256Of(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	s.resumeStatePath = filepath.Join(s.TrustedRoot, fmt.Sprintf(".%s.upload-state", s.Filename))
+
+	cu, chunked := client.(ChunkedUploader)
+
+	st, _ := loadUploadState(s.resumeStatePath)
+	offset := resumeOffset(chunked, st, s.Filename, sum)
+
+	progress := func(uploaded int64) {
+		ui.Message(fmt.Sprintf("Uploading %s: %d/%d bytes", s.Filename, uploaded, size))
+		if err := saveUploadState(s.resumeStatePath, &uploadState{Filename: s.Filename, SHA256: sum, BytesUploaded: uploaded}); err != nil {
+			log.Printf("saving upload resume state: %s", err)
+		}
+	}
+
+	if offset > 0 {
+		ui.Say(fmt.Sprintf("Resuming upload of %s from byte %d", s.Filename, offset))
+	} else {
+		ui.Say(fmt.Sprintf("Uploading %s...", s.Filename))
+	}
+
+	if chunked {
+		err = cu.UploadChunked(ctx, s.Node, s.StoragePool, s.ContentType, s.Filename, r, size, offset, defaultUploadChunkSize, progress)
+	} else {
+		err = client.Upload(s.Node, s.StoragePool, s.ContentType, s.Filename, io.NewSectionReader(r, offset, size-offset))
+	}
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if v, ok := client.(uploadVerifier); ok {
+		if err := v.VerifyUpload(s.Node, s.StoragePool, s.ContentType, s.Filename, sum); err != nil {
+			err = fmt.Errorf("verifying upload of %s: %w", s.Filename, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	dir, ok := contentTypeVolumeDir[s.ContentType]
+	if !ok {
+		dir = s.ContentType
+	}
+	state.Put(s.TargetStoragePathKey, fmt.Sprintf("%s:%s/%s", s.StoragePool, dir, s.Filename))
+
+	return multistep.ActionContinue
+}
+
+// Cleanup removes the resume-state file once the upload (or the whole
+// build) has finished successfully. On failure it's left in place so a
+// subsequent run can resume from where this one stopped.
+func (s *stepUploadFile) Cleanup(state multistep.StateBag) {
+	if s.resumeStatePath == "" {
+		return
+	}
+	if _, ok := state.GetOk("error"); ok {
+		return
+	}
+
+	if err := os.Remove(s.resumeStatePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("removing upload resume state %s: %s", s.resumeStatePath, err)
+	}
+}
+
+// validateStorageContentType confirms storage is configured to accept
+// contentType before we spend time uploading to it. Clients that don't
+// implement storageContentLister skip the check and let Proxmox itself
+// be the source of truth.
+func validateStorageContentType(client uploader, node, storage, contentType string) error {
+	lister, ok := client.(storageContentLister)
+	if !ok {
+		return nil
+	}
+
+	allowed, err := lister.GetStorageContentTypes(node, storage)
+	if err != nil {
+		return fmt.Errorf("checking content types allowed on storage %q: %w", storage, err)
+	}
+
+	for _, t := range allowed {
+		if t == contentType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("storage %q does not permit content type %q (allows: %s)", storage, contentType, strings.Join(allowed, ", "))
+}
+
+// resumeOffset decides where to resume an upload from. A plain uploader
+// has no way to append to or resume an in-progress remote object:
+// sending it a reader that starts partway through the file would
+// silently upload a truncated (or, on stale state, zero-byte) file as
+// if it were the whole thing. So a saved checkpoint is only honored
+// when client supports chunked upload and the checkpoint matches the
+// file being uploaded now.
+func resumeOffset(chunked bool, st *uploadState, filename, sha256 string) int64 {
+	if !chunked || st == nil || st.Filename != filename || st.SHA256 != sha256 {
+		return 0
+	}
+	return st.BytesUploaded
+}
+
+func sha256Of(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func loadUploadState(path string) (*uploadState, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var st uploadState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func saveUploadState(path string, st *uploadState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
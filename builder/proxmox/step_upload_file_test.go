@@ -0,0 +1,107 @@
+package proxmox
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResumeOffset(t *testing.T) {
+	matching := &uploadState{Filename: "disk.iso", SHA256: "abc", BytesUploaded: 4096}
+
+	cases := []struct {
+		name    string
+		chunked bool
+		st      *uploadState
+		want    int64
+	}{
+		{"chunked with matching state resumes", true, matching, 4096},
+		{"chunked with no state starts at zero", true, nil, 0},
+		{"chunked with mismatched filename starts at zero", true, &uploadState{Filename: "other.iso", SHA256: "abc", BytesUploaded: 4096}, 0},
+		{"chunked with mismatched checksum starts at zero", true, &uploadState{Filename: "disk.iso", SHA256: "def", BytesUploaded: 4096}, 0},
+		{"non-chunked with matching state still starts at zero", false, matching, 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resumeOffset(tt.chunked, tt.st, "disk.iso", "abc")
+			if got != tt.want {
+				t.Fatalf("resumeOffset(%v, %v) = %d, want %d", tt.chunked, tt.st, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveLoadUploadState_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".disk.iso.upload-state")
+	want := &uploadState{Filename: "disk.iso", SHA256: "abc123", BytesUploaded: 123456}
+
+	if err := saveUploadState(path, want); err != nil {
+		t.Fatalf("saveUploadState: %s", err)
+	}
+
+	got, err := loadUploadState(path)
+	if err != nil {
+		t.Fatalf("loadUploadState: %s", err)
+	}
+	if *got != *want {
+		t.Fatalf("loadUploadState = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadUploadState_MissingFile(t *testing.T) {
+	if _, err := loadUploadState(filepath.Join(t.TempDir(), "does-not-exist")); err == nil {
+		t.Fatal("loadUploadState: expected an error for a missing file, got nil")
+	}
+}
+
+func TestSha256Of(t *testing.T) {
+	got, err := sha256Of(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("sha256Of: %s", err)
+	}
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Fatalf("sha256Of(\"hello\") = %q, want %q", got, want)
+	}
+}
+
+type fakeContentLister struct {
+	uploader
+	allowed []string
+	err     error
+}
+
+func (f *fakeContentLister) GetStorageContentTypes(node, storage string) ([]string, error) {
+	return f.allowed, f.err
+}
+
+func TestValidateStorageContentType(t *testing.T) {
+	t.Run("allowed type passes", func(t *testing.T) {
+		client := &fakeContentLister{allowed: []string{"iso", "snippets"}}
+		if err := validateStorageContentType(client, "pve", "local", "iso"); err != nil {
+			t.Fatalf("validateStorageContentType: unexpected error: %s", err)
+		}
+	})
+
+	t.Run("disallowed type is rejected", func(t *testing.T) {
+		client := &fakeContentLister{allowed: []string{"snippets"}}
+		if err := validateStorageContentType(client, "pve", "local", "iso"); err == nil {
+			t.Fatal("validateStorageContentType: expected an error for a disallowed content type, got nil")
+		}
+	})
+
+	t.Run("client without storageContentLister is skipped", func(t *testing.T) {
+		var client uploader = plainUploaderStub{}
+		if err := validateStorageContentType(client, "pve", "local", "iso"); err != nil {
+			t.Fatalf("validateStorageContentType: unexpected error: %s", err)
+		}
+	})
+}
+
+type plainUploaderStub struct{}
+
+func (plainUploaderStub) Upload(node, storage, contentType, filename string, file io.Reader) error {
+	return nil
+}
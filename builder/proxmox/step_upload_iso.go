@@ -2,72 +2,50 @@ package proxmox
 
 import (
 	"context"
-	"fmt"
-	"io"
-	"os"
 	"path/filepath"
 
-	"github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/packer/helper/multistep"
-	"github.com/hashicorp/packer/packer"
 )
 
-// stepUploadISO uploads an ISO file to Proxmox so we can boot from it
+// stepUploadISO uploads an ISO file to Proxmox so we can boot from it.
+// It's a thin "iso"-flavored wrapper around the shared stepUploadFile.
 type stepUploadISO struct {
 	ShouldUpload         bool
 	DownloadPathKey      string
 	TargetStoragePathKey string
 	ISOUrls              []string
 	ISOFile              string
-}
 
-type uploader interface {
-	Upload(node string, storage string, contentType string, filename string, file io.Reader) error
-}
+	// TrustedRoot is the download cache directory the path in
+	// DownloadPathKey is expected to live under. The path is opened
+	// relative to this root with symlinks refused, rather than resolved
+	// and opened in two separate steps, so a download-step race can't
+	// redirect the upload to an arbitrary file.
+	TrustedRoot string
 
-var _ uploader = &proxmox.Client{}
+	inner *stepUploadFile
+}
 
 func (s *stepUploadISO) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
-	ui := state.Get("ui").(packer.Ui)
-	client := state.Get("proxmoxClient").(uploader)
 	c := state.Get("config").(*Config)
 
-	if !s.ShouldUpload {
-		state.Put(s.TargetStoragePathKey, s.ISOFile)
-		return multistep.ActionContinue
+	s.inner = &stepUploadFile{
+		ShouldUpload:         s.ShouldUpload,
+		PreUploadedPath:      s.ISOFile,
+		SourcePathKey:        s.DownloadPathKey,
+		TrustedRoot:          s.TrustedRoot,
+		Node:                 c.Node,
+		StoragePool:          c.ISOStoragePool,
+		ContentType:          "iso",
+		Filename:             filepath.Base(s.ISOUrls[0]),
+		TargetStoragePathKey: s.TargetStoragePathKey,
 	}
 
-	p := state.Get(s.DownloadPathKey).(string)
-	if p == "" {
-		err := fmt.Errorf("Path to downloaded ISO was empty")
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-
-	// All failure cases in resolving the symlink are caught anyway in os.Open
-	isoPath, _ := filepath.EvalSymlinks(p)
-	r, err := os.Open(isoPath)
-	if err != nil {
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-
-	filename := filepath.Base(s.ISOUrls[0])
-	ui.Say(fmt.Sprintf("Uploading %s...", filename))
-	err = client.Upload(c.Node, c.ISOStoragePool, "iso", filename, r)
-	if err != nil {
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-
-	isoStoragePath := fmt.Sprintf("%s:iso/%s", c.ISOStoragePool, filename)
-	state.Put(s.TargetStoragePathKey, isoStoragePath)
-
-	return multistep.ActionContinue
+	return s.inner.Run(ctx, state)
 }
 
 func (s *stepUploadISO) Cleanup(state multistep.StateBag) {
+	if s.inner != nil {
+		s.inner.Cleanup(state)
+	}
 }
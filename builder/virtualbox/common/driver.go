@@ -0,0 +1,138 @@
+package common
+
+import (
+	"fmt"
+
+	versionUtil "github.com/hashicorp/go-version"
+)
+
+// Driver is the interface that's shared between the different ways to
+// drive VirtualBox: shelling out to VBoxManage for every call, or talking
+// to vboxwebsrv directly. The builder and its steps only ever see this
+// interface, so they don't care which one is actually running a given
+// build.
+type Driver interface {
+	// CreateSATAController creates a SATA controller with the given name
+	// and port count attached to the VM.
+	CreateSATAController(vmName string, name string, portcount int) error
+
+	// CreateSCSIController creates a SCSI controller with the given name
+	// attached to the VM.
+	CreateSCSIController(vmName string, name string) error
+
+	// Delete deletes a VM by name.
+	Delete(name string) error
+
+	// Iso finds the path to the core virtual box ISO.
+	Iso() (string, error)
+
+	// Import imports a VM that was exported.
+	Import(name string, path string, flags []string) error
+
+	// IsRunning checks if a VM is running.
+	IsRunning(name string) (bool, error)
+
+	// Stop stops a running machine, forcefully.
+	Stop(name string) error
+
+	// SuppressMessages suppresses any annoying popups from VirtualBox.
+	SuppressMessages() error
+
+	// Verify checks to make sure that this driver should function
+	// properly. If there is any indication the driver can't function,
+	// this will return an error.
+	Verify() error
+
+	// Version reads the version of VirtualBox that is installed.
+	Version() (string, error)
+
+	// LoadSnapshots load the snapshots for a VM instance
+	LoadSnapshots(vmName string) (*VBoxSnapshot, error)
+
+	// CreateSnapshot takes a snapshot of a VM.
+	CreateSnapshot(vmname string, snapshotName string) error
+
+	// SetSnapshot restores a VM to a previously taken snapshot.
+	SetSnapshot(vmname string, sn *VBoxSnapshot) error
+
+	// DeleteSnapshot removes a previously taken snapshot.
+	DeleteSnapshot(vmname string, sn *VBoxSnapshot) error
+
+	// SupportsPortCount reports whether CreateSATAController can use the
+	// "--portcount" flag, versus the older "--sataportcount".
+	SupportsPortCount() bool
+
+	// SupportsNestedVirt reports whether the running VirtualBox can
+	// expose nested virtualization to a guest.
+	SupportsNestedVirt() bool
+
+	// SupportsParavirtProvider reports whether "--paravirtprovider" is
+	// a recognized modifyvm setting.
+	SupportsParavirtProvider() bool
+}
+
+// versionAtLeast reports whether getVersion returns a version string
+// that parses to at least min. Callers use it to back capability probes
+// instead of comparing raw version strings inline at every call site.
+func versionAtLeast(getVersion func() (string, error), min string) bool {
+	version, err := getVersion()
+	if err != nil {
+		return false
+	}
+
+	current, err := versionUtil.NewVersion(version)
+	if err != nil {
+		return false
+	}
+
+	minVersion, err := versionUtil.NewVersion(min)
+	if err != nil {
+		return false
+	}
+
+	return !current.LessThan(minVersion)
+}
+
+// DriverConfig holds what's needed to construct either concrete Driver.
+// Which fields matter depends on Type.
+type DriverConfig struct {
+	// Type selects the driver implementation: "vboxmanage" (the
+	// default) shells out to the VBoxManage binary, "websrv" talks to
+	// an already-running vboxwebsrv over SOAP.
+	Type string
+
+	VBoxManagePath string
+
+	WebsrvEndpoint string
+	WebsrvUsername string
+	WebsrvPassword string
+}
+
+// NewDriver builds the Driver selected by config.Type, defaulting to the
+// VBoxManage-based driver so existing `driver_type`-less configs keep
+// working unchanged. Each concrete driver is responsible for its own
+// version/platform gating internally (via the Supports* capability
+// probes), so adding a driver for a newer VirtualBox release doesn't
+// require touching this dispatch.
+func NewDriver(config DriverConfig) (Driver, error) {
+	switch config.Type {
+	case "", "vboxmanage":
+		driver := &VBox42Driver{VBoxManagePath: config.VBoxManagePath}
+		if err := driver.Verify(); err != nil {
+			return nil, fmt.Errorf("vboxmanage driver unavailable: %w", err)
+		}
+		return driver, nil
+	case "websrv":
+		driver := &VBoxWebDriver{
+			Endpoint: config.WebsrvEndpoint,
+			Username: config.WebsrvUsername,
+			Password: config.WebsrvPassword,
+		}
+		if err := driver.Verify(); err != nil {
+			return nil, fmt.Errorf("websrv driver unavailable: %w", err)
+		}
+		return driver, nil
+	default:
+		return nil, fmt.Errorf("unknown driver_type %q", config.Type)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"time"
 
 	"github.com/golang-collections/collections/stack"
-	versionUtil "github.com/hashicorp/go-version"
 	packer "github.com/hashicorp/packer/common"
 )
 
@@ -22,25 +21,12 @@ type VBox42Driver struct {
 	VBoxManagePath string
 }
 
-func (d *VBox42Driver) CreateSATAController(vmName string, name string, portcount int) error {
-	version, err := d.Version()
-	if err != nil {
-		return err
-	}
-
-	portCountArg := "--portcount"
-
-	currentVersion, err := versionUtil.NewVersion(version)
-	if err != nil {
-		return err
-	}
-	firstVersionUsingPortCount, err := versionUtil.NewVersion("4.3")
-	if err != nil {
-		return err
-	}
+var _ Driver = new(VBox42Driver)
 
-	if currentVersion.LessThan(firstVersionUsingPortCount) {
-		portCountArg = "--sataportcount"
+func (d *VBox42Driver) CreateSATAController(vmName string, name string, portcount int) error {
+	portCountArg := "--sataportcount"
+	if d.SupportsPortCount() {
+		portCountArg = "--portcount"
 	}
 
 	command := []string{
@@ -247,6 +233,24 @@ func (d *VBox42Driver) Version() (string, error) {
 	return matches[0][1], nil
 }
 
+// SupportsPortCount reports whether CreateSATAController can use the
+// "--portcount" flag, which replaced "--sataportcount" in VirtualBox 4.3.
+func (d *VBox42Driver) SupportsPortCount() bool {
+	return versionAtLeast(d.Version, "4.3")
+}
+
+// SupportsNestedVirt reports whether the running VirtualBox can expose
+// nested virtualization to a guest, added in VirtualBox 6.0.
+func (d *VBox42Driver) SupportsNestedVirt() bool {
+	return versionAtLeast(d.Version, "6.0")
+}
+
+// SupportsParavirtProvider reports whether "--paravirtprovider" is a
+// recognized modifyvm setting, added in VirtualBox 5.0.
+func (d *VBox42Driver) SupportsParavirtProvider() bool {
+	return versionAtLeast(d.Version, "5.0")
+}
+
 // LoadSnapshots load the snapshots for a VM instance
 func (d *VBox42Driver) LoadSnapshots(vmName string) (*VBoxSnapshot, error) {
 	if vmName == "" {
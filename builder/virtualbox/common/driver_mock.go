@@ -0,0 +1,142 @@
+package common
+
+// DriverMock is a Driver implementation that records the calls made to
+// it and returns canned results, for use by unit tests of the steps and
+// builder that depend on a Driver without driving real VirtualBox.
+type DriverMock struct {
+	CreateSATAControllerVMName    string
+	CreateSATAControllerName      string
+	CreateSATAControllerPortcount int
+	CreateSATAControllerErr       error
+
+	CreateSCSIControllerVMName string
+	CreateSCSIControllerName   string
+	CreateSCSIControllerErr    error
+
+	DeleteName string
+	DeleteErr  error
+
+	IsoResult string
+	IsoErr    error
+
+	ImportName  string
+	ImportPath  string
+	ImportFlags []string
+	ImportErr   error
+
+	IsRunningName   string
+	IsRunningResult bool
+	IsRunningErr    error
+
+	StopName string
+	StopErr  error
+
+	SuppressMessagesErr error
+
+	VerifyErr error
+
+	VersionResult string
+	VersionErr    error
+
+	LoadSnapshotsVMName string
+	LoadSnapshotsResult *VBoxSnapshot
+	LoadSnapshotsErr    error
+
+	CreateSnapshotVMName       string
+	CreateSnapshotSnapshotName string
+	CreateSnapshotErr          error
+
+	SetSnapshotVMName string
+	SetSnapshotSn     *VBoxSnapshot
+	SetSnapshotErr    error
+
+	DeleteSnapshotVMName string
+	DeleteSnapshotSn     *VBoxSnapshot
+	DeleteSnapshotErr    error
+
+	SupportsPortCountResult        bool
+	SupportsNestedVirtResult       bool
+	SupportsParavirtProviderResult bool
+}
+
+func (d *DriverMock) CreateSATAController(vmName string, name string, portcount int) error {
+	d.CreateSATAControllerVMName = vmName
+	d.CreateSATAControllerName = name
+	d.CreateSATAControllerPortcount = portcount
+	return d.CreateSATAControllerErr
+}
+
+func (d *DriverMock) CreateSCSIController(vmName string, name string) error {
+	d.CreateSCSIControllerVMName = vmName
+	d.CreateSCSIControllerName = name
+	return d.CreateSCSIControllerErr
+}
+
+func (d *DriverMock) Delete(name string) error {
+	d.DeleteName = name
+	return d.DeleteErr
+}
+
+func (d *DriverMock) Iso() (string, error) {
+	return d.IsoResult, d.IsoErr
+}
+
+func (d *DriverMock) Import(name string, path string, flags []string) error {
+	d.ImportName = name
+	d.ImportPath = path
+	d.ImportFlags = flags
+	return d.ImportErr
+}
+
+func (d *DriverMock) IsRunning(name string) (bool, error) {
+	d.IsRunningName = name
+	return d.IsRunningResult, d.IsRunningErr
+}
+
+func (d *DriverMock) Stop(name string) error {
+	d.StopName = name
+	return d.StopErr
+}
+
+func (d *DriverMock) SuppressMessages() error {
+	return d.SuppressMessagesErr
+}
+
+func (d *DriverMock) Verify() error {
+	return d.VerifyErr
+}
+
+func (d *DriverMock) Version() (string, error) {
+	return d.VersionResult, d.VersionErr
+}
+
+func (d *DriverMock) LoadSnapshots(vmName string) (*VBoxSnapshot, error) {
+	d.LoadSnapshotsVMName = vmName
+	return d.LoadSnapshotsResult, d.LoadSnapshotsErr
+}
+
+func (d *DriverMock) CreateSnapshot(vmname string, snapshotName string) error {
+	d.CreateSnapshotVMName = vmname
+	d.CreateSnapshotSnapshotName = snapshotName
+	return d.CreateSnapshotErr
+}
+
+func (d *DriverMock) SetSnapshot(vmname string, sn *VBoxSnapshot) error {
+	d.SetSnapshotVMName = vmname
+	d.SetSnapshotSn = sn
+	return d.SetSnapshotErr
+}
+
+func (d *DriverMock) DeleteSnapshot(vmname string, sn *VBoxSnapshot) error {
+	d.DeleteSnapshotVMName = vmname
+	d.DeleteSnapshotSn = sn
+	return d.DeleteSnapshotErr
+}
+
+func (d *DriverMock) SupportsPortCount() bool { return d.SupportsPortCountResult }
+
+func (d *DriverMock) SupportsNestedVirt() bool { return d.SupportsNestedVirtResult }
+
+func (d *DriverMock) SupportsParavirtProvider() bool { return d.SupportsParavirtProviderResult }
+
+var _ Driver = new(DriverMock)
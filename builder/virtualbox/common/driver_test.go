@@ -0,0 +1,34 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+// These exercise versionAtLeast -- the helper every Supports* capability
+// probe is built on -- against DriverMock, standing in for a real
+// VBox42Driver/VBoxWebDriver whose Version() shells out or makes a SOAP
+// call.
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		name string
+		mock *DriverMock
+		min  string
+		want bool
+	}{
+		{"newer", &DriverMock{VersionResult: "6.1.0"}, "6.0", true},
+		{"exact", &DriverMock{VersionResult: "6.0.0"}, "6.0", true},
+		{"older", &DriverMock{VersionResult: "5.2.0"}, "6.0", false},
+		{"version error", &DriverMock{VersionErr: errors.New("vboxmanage not found")}, "6.0", false},
+		{"unparseable version", &DriverMock{VersionResult: "not-a-version"}, "6.0", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := versionAtLeast(tt.mock.Version, tt.min)
+			if got != tt.want {
+				t.Fatalf("versionAtLeast(%q, %q) = %v, want %v", tt.mock.VersionResult, tt.min, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,789 @@
+package common
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VBoxWebDriver drives VirtualBox through vboxwebsrv's SOAP API instead of
+// shelling out to VBoxManage for every operation. Where VBox42Driver pays
+// an exec.Command cost per call and recovers structured information by
+// parsing --machinereadable/textual VBoxManage output, this driver talks
+// to already-live COM objects (IVirtualBox, IMachine, ISession, ...) over
+// the wire and gets typed results and SOAP faults back directly.
+type VBoxWebDriver struct {
+	// Endpoint is the vboxwebsrv URL, e.g. "http://127.0.0.1:18083".
+	Endpoint string
+	Username string
+	Password string
+
+	httpClient *http.Client
+	// managedObjRef is the IWebsessionManager session handle obtained on
+	// logon; every subsequent call threads it through as the "this"
+	// argument to the COM method being invoked.
+	managedObjRef string
+}
+
+// vboxWebFault is the subset of a SOAP Fault vboxwebsrv returns when a COM
+// call fails, e.g. VBOX_E_OBJECT_NOT_FOUND or VBOX_E_INVALID_VM_STATE.
+// Matching on this struct replaces the regexp over VBoxManage's stderr
+// text that VBox42Driver relies on.
+type vboxWebFault struct {
+	XMLName     xml.Name `xml:"Fault"`
+	FaultString string   `xml:"faultstring"`
+	ResultCode  string   `xml:"detail>VirtualBoxErrorInfo>resultCode"`
+	Component   string   `xml:"detail>VirtualBoxErrorInfo>component"`
+	Text        string   `xml:"detail>VirtualBoxErrorInfo>text"`
+}
+
+type vboxWebEnvelope struct {
+	XMLName xml.Name    `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    vboxWebBody `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+type vboxWebBody struct {
+	Fault   *vboxWebFault `xml:"Fault"`
+	Content []byte        `xml:",innerxml"`
+}
+
+// xmlEscape renders s safely as SOAP/XML element content, so values we
+// don't control the shape of — VM/snapshot names, paths, credentials —
+// can't break the envelope (or inject content) when they contain
+// characters like &, <, >, or ".
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	if err := xml.EscapeText(&buf, []byte(s)); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+func (d *VBoxWebDriver) client() *http.Client {
+	if d.httpClient == nil {
+		d.httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	return d.httpClient
+}
+
+// call invokes the named vboxwebsrv COM method with a pre-built SOAP
+// request body and returns the raw <...Response> content for the caller
+// to unmarshal, or the error carried in a SOAP Fault if one came back.
+func (d *VBoxWebDriver) call(method string, body []byte) ([]byte, error) {
+	envelope := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<SOAP-ENV:Body>`)
+	envelope = append(envelope, body...)
+	envelope = append(envelope, []byte(`</SOAP-ENV:Body></SOAP-ENV:Envelope>`)...)
+
+	req, err := http.NewRequest(http.MethodPost, d.Endpoint, bytes.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", method)
+
+	log.Printf("Executing vboxwebsrv call: %s", method)
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vboxwebsrv %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var envResp vboxWebEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envResp); err != nil {
+		return nil, fmt.Errorf("vboxwebsrv %s: decoding response: %w", method, err)
+	}
+
+	if envResp.Body.Fault != nil {
+		f := envResp.Body.Fault
+		return nil, fmt.Errorf("vboxwebsrv %s: %s (%s: %s)", method, f.ResultCode, f.Component, f.Text)
+	}
+
+	return envResp.Body.Content, nil
+}
+
+// logon authenticates against the endpoint and stashes the session's
+// managed object reference for subsequent calls. It's idempotent: calling
+// it again just re-logs on.
+func (d *VBoxWebDriver) logon() error {
+	body := []byte(fmt.Sprintf(
+		`<IWebsessionManager_logon xmlns="http://www.virtualbox.org/"><username>%s</username><password>%s</password></IWebsessionManager_logon>`,
+		xmlEscape(d.Username), xmlEscape(d.Password)))
+
+	resp, err := d.call("IWebsessionManager_logon", body)
+	if err != nil {
+		return fmt.Errorf("logging on to %s: %w", d.Endpoint, err)
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return fmt.Errorf("logging on to %s: %w", d.Endpoint, err)
+	}
+	if parsed.Returnval == "" {
+		return fmt.Errorf("logging on to %s: empty session handle", d.Endpoint)
+	}
+
+	d.managedObjRef = parsed.Returnval
+	return nil
+}
+
+// Verify authenticates against the endpoint and reads the remote
+// VirtualBox version. The version is only logged; it is not checked
+// against any supported range.
+func (d *VBoxWebDriver) Verify() error {
+	if err := d.logon(); err != nil {
+		return err
+	}
+
+	version, err := d.Version()
+	if err != nil {
+		return fmt.Errorf("vboxwebsrv at %s: %w", d.Endpoint, err)
+	}
+
+	log.Printf("Connected to vboxwebsrv %s, VirtualBox version %s", d.Endpoint, version)
+	return nil
+}
+
+func (d *VBoxWebDriver) Version() (string, error) {
+	if d.managedObjRef == "" {
+		if err := d.logon(); err != nil {
+			return "", err
+		}
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<IVirtualBox_getVersion xmlns="http://www.virtualbox.org/"><_this>%s</_this></IVirtualBox_getVersion>`,
+		xmlEscape(d.managedObjRef)))
+
+	resp, err := d.call("IVirtualBox_getVersion", body)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return "", err
+	}
+
+	return parsed.Returnval, nil
+}
+
+func (d *VBoxWebDriver) CreateSATAController(vmName string, name string, portcount int) error {
+	return d.withMachineSession(vmName, func(machine, session string) error {
+		body := []byte(fmt.Sprintf(
+			`<IMachine_addStorageController xmlns="http://www.virtualbox.org/"><_this>%s</_this><name>%s</name><connectionType>SATA</connectionType></IMachine_addStorageController>`,
+			xmlEscape(machine), xmlEscape(name)))
+		ctrl, err := d.call("IMachine_addStorageController", body)
+		if err != nil {
+			return err
+		}
+
+		var parsed struct {
+			Returnval string `xml:"returnval"`
+		}
+		if err := xml.Unmarshal(ctrl, &parsed); err != nil {
+			return err
+		}
+
+		portCountBody := []byte(fmt.Sprintf(
+			`<IStorageController_setPortCount xmlns="http://www.virtualbox.org/"><_this>%s</_this><portCount>%d</portCount></IStorageController_setPortCount>`,
+			xmlEscape(parsed.Returnval), portcount))
+		if _, err := d.call("IStorageController_setPortCount", portCountBody); err != nil {
+			return err
+		}
+
+		return d.saveMachineSettings(machine)
+	})
+}
+
+func (d *VBoxWebDriver) CreateSCSIController(vmName string, name string) error {
+	return d.withMachineSession(vmName, func(machine, session string) error {
+		body := []byte(fmt.Sprintf(
+			`<IMachine_addStorageController xmlns="http://www.virtualbox.org/"><_this>%s</_this><name>%s</name><connectionType>LSILogic</connectionType></IMachine_addStorageController>`,
+			xmlEscape(machine), xmlEscape(name)))
+		if _, err := d.call("IMachine_addStorageController", body); err != nil {
+			return err
+		}
+
+		return d.saveMachineSettings(machine)
+	})
+}
+
+func (d *VBoxWebDriver) Delete(name string) error {
+	machine, err := d.findMachine(name)
+	if err != nil {
+		return err
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<IMachine_unregister xmlns="http://www.virtualbox.org/"><_this>%s</_this><cleanupMode>Full</cleanupMode></IMachine_unregister>`,
+		xmlEscape(machine)))
+	resp, err := d.call("IMachine_unregister", body)
+	if err != nil {
+		return err
+	}
+
+	var media struct {
+		Returnval []string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &media); err != nil {
+		return err
+	}
+
+	var mediaXML strings.Builder
+	for _, m := range media.Returnval {
+		fmt.Fprintf(&mediaXML, "<media>%s</media>", xmlEscape(m))
+	}
+
+	deleteBody := []byte(fmt.Sprintf(
+		`<IMachine_deleteConfig xmlns="http://www.virtualbox.org/"><_this>%s</_this>%s</IMachine_deleteConfig>`,
+		xmlEscape(machine), mediaXML.String()))
+	_, err = d.call("IMachine_deleteConfig", deleteBody)
+	return err
+}
+
+func (d *VBoxWebDriver) Iso() (string, error) {
+	if d.managedObjRef == "" {
+		if err := d.logon(); err != nil {
+			return "", err
+		}
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<ISystemProperties_getDefaultAdditionsISO xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISystemProperties_getDefaultAdditionsISO>`,
+		xmlEscape(d.managedObjRef)))
+
+	resp, err := d.call("ISystemProperties_getDefaultAdditionsISO", body)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return "", err
+	}
+
+	return parsed.Returnval, nil
+}
+
+// virtualSystemDescriptionTypeName is the VirtualSystemDescriptionType
+// enum member that names a virtual system within an appliance; it's the
+// entry renameVirtualSystem overrides so the imported machine is
+// registered as name instead of whatever the OVF itself specifies.
+const virtualSystemDescriptionTypeName = "Name"
+
+// importOptionNames maps the lowercase values VBoxManage's "import
+// --options" accepts to the SOAP ImportOptions enum member that does
+// the same thing, so Import's flags argument means the same thing
+// regardless of which driver is selected.
+var importOptionNames = map[string]string{
+	"keepallmacs": "KeepAllMACs",
+	"keepnatmacs": "KeepNATMACs",
+	"importtovdi": "ImportToVDI",
+}
+
+func (d *VBoxWebDriver) Import(name string, path string, flags []string) error {
+	body := []byte(fmt.Sprintf(
+		`<IVirtualBox_createAppliance xmlns="http://www.virtualbox.org/"><_this>%s</_this></IVirtualBox_createAppliance>`,
+		xmlEscape(d.managedObjRef)))
+	appliance, err := d.call("IVirtualBox_createAppliance", body)
+	if err != nil {
+		return err
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(appliance, &parsed); err != nil {
+		return err
+	}
+	applianceRef := parsed.Returnval
+
+	readBody := []byte(fmt.Sprintf(
+		`<IAppliance_read xmlns="http://www.virtualbox.org/"><_this>%s</_this><path>%s</path></IAppliance_read>`,
+		xmlEscape(applianceRef), xmlEscape(path)))
+	if _, err := d.call("IAppliance_read", readBody); err != nil {
+		return err
+	}
+
+	interpretBody := []byte(fmt.Sprintf(
+		`<IAppliance_interpret xmlns="http://www.virtualbox.org/"><_this>%s</_this></IAppliance_interpret>`,
+		xmlEscape(applianceRef)))
+	if _, err := d.call("IAppliance_interpret", interpretBody); err != nil {
+		return err
+	}
+
+	if err := d.renameVirtualSystems(applianceRef, name); err != nil {
+		return err
+	}
+
+	var optionsXML strings.Builder
+	for _, opt := range importOptions(flags) {
+		fmt.Fprintf(&optionsXML, "<options>%s</options>", xmlEscape(opt))
+	}
+
+	importBody := []byte(fmt.Sprintf(
+		`<IAppliance_importMachines xmlns="http://www.virtualbox.org/"><_this>%s</_this>%s</IAppliance_importMachines>`,
+		xmlEscape(applianceRef), optionsXML.String()))
+	_, err = d.call("IAppliance_importMachines", importBody)
+	return err
+}
+
+// renameVirtualSystems walks every virtual system description in the
+// appliance and overrides its name, so the machine(s) importMachines
+// registers land under name rather than whatever the OVF describes.
+func (d *VBoxWebDriver) renameVirtualSystems(applianceRef, name string) error {
+	body := []byte(fmt.Sprintf(
+		`<IAppliance_getVirtualSystemDescriptions xmlns="http://www.virtualbox.org/"><_this>%s</_this></IAppliance_getVirtualSystemDescriptions>`,
+		xmlEscape(applianceRef)))
+	resp, err := d.call("IAppliance_getVirtualSystemDescriptions", body)
+	if err != nil {
+		return err
+	}
+
+	var descriptions struct {
+		Returnval []string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &descriptions); err != nil {
+		return err
+	}
+
+	for _, vsdRef := range descriptions.Returnval {
+		if err := d.renameVirtualSystem(vsdRef, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameVirtualSystem overrides vsdRef's Name entry with name via
+// setFinalValues, enabling and otherwise leaving untouched every other
+// entry (OS type, CPU count, disks, ...) exactly as the OVF described it.
+func (d *VBoxWebDriver) renameVirtualSystem(vsdRef, name string) error {
+	body := []byte(fmt.Sprintf(
+		`<IVirtualSystemDescription_getDescription xmlns="http://www.virtualbox.org/"><_this>%s</_this></IVirtualSystemDescription_getDescription>`,
+		xmlEscape(vsdRef)))
+	resp, err := d.call("IVirtualSystemDescription_getDescription", body)
+	if err != nil {
+		return err
+	}
+
+	var desc struct {
+		Types  []string `xml:"types"`
+		Values []string `xml:"vboxValues"`
+		Extra  []string `xml:"extraConfigValues"`
+	}
+	if err := xml.Unmarshal(resp, &desc); err != nil {
+		return err
+	}
+
+	var enabledXML, valuesXML, extraXML strings.Builder
+	for i, t := range desc.Types {
+		value := stringAt(desc.Values, i)
+		if t == virtualSystemDescriptionTypeName {
+			value = name
+		}
+		fmt.Fprintf(&enabledXML, "<enabled>true</enabled>")
+		fmt.Fprintf(&valuesXML, "<vboxValues>%s</vboxValues>", xmlEscape(value))
+		fmt.Fprintf(&extraXML, "<extraConfigValues>%s</extraConfigValues>", xmlEscape(stringAt(desc.Extra, i)))
+	}
+
+	setBody := []byte(fmt.Sprintf(
+		`<IVirtualSystemDescription_setFinalValues xmlns="http://www.virtualbox.org/"><_this>%s</_this>%s%s%s</IVirtualSystemDescription_setFinalValues>`,
+		xmlEscape(vsdRef), enabledXML.String(), valuesXML.String(), extraXML.String()))
+	_, err = d.call("IVirtualSystemDescription_setFinalValues", setBody)
+	return err
+}
+
+// importOptions extracts the values following a "--options" flag out of
+// a VBoxManage-style flags slice and translates each into its SOAP
+// ImportOptions enum member, dropping anything unrecognized.
+func importOptions(flags []string) []string {
+	var opts []string
+	for i, f := range flags {
+		if f != "--options" || i+1 >= len(flags) {
+			continue
+		}
+		for _, v := range strings.Split(flags[i+1], ",") {
+			if opt, ok := importOptionNames[strings.ToLower(v)]; ok {
+				opts = append(opts, opt)
+			}
+		}
+	}
+	return opts
+}
+
+// stringAt returns ss[i], or "" if i is out of range -- a malformed or
+// unexpectedly short vboxwebsrv response shouldn't panic the import.
+func stringAt(ss []string, i int) string {
+	if i < 0 || i >= len(ss) {
+		return ""
+	}
+	return ss[i]
+}
+
+func (d *VBoxWebDriver) IsRunning(name string) (bool, error) {
+	machine, err := d.findMachine(name)
+	if err != nil {
+		return false, err
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<IMachine_getState xmlns="http://www.virtualbox.org/"><_this>%s</_this></IMachine_getState>`,
+		xmlEscape(machine)))
+	resp, err := d.call("IMachine_getState", body)
+	if err != nil {
+		return false, err
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return false, err
+	}
+
+	switch parsed.Returnval {
+	case "Running", "Stopping", "Paused":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (d *VBoxWebDriver) Stop(name string) error {
+	return d.withMachineSession(name, func(machine, session string) error {
+		body := []byte(fmt.Sprintf(
+			`<ISession_getConsole xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISession_getConsole>`,
+			xmlEscape(session)))
+		resp, err := d.call("ISession_getConsole", body)
+		if err != nil {
+			return err
+		}
+
+		var parsed struct {
+			Returnval string `xml:"returnval"`
+		}
+		if err := xml.Unmarshal(resp, &parsed); err != nil {
+			return err
+		}
+
+		powerDownBody := []byte(fmt.Sprintf(
+			`<IConsole_powerDown xmlns="http://www.virtualbox.org/"><_this>%s</_this></IConsole_powerDown>`,
+			xmlEscape(parsed.Returnval)))
+		_, err = d.call("IConsole_powerDown", powerDownBody)
+		return err
+	})
+}
+
+func (d *VBoxWebDriver) SuppressMessages() error {
+	if d.managedObjRef == "" {
+		if err := d.logon(); err != nil {
+			return err
+		}
+	}
+
+	extraData := map[string]string{
+		"GUI/RegistrationData": "triesLeft=0",
+		"GUI/SuppressMessages": "confirmInputCapture,remindAboutAutoCapture,remindAboutMouseIntegrationOff,remindAboutMouseIntegrationOn,remindAboutWrongColorDepth",
+		"GUI/UpdateDate":       fmt.Sprintf("1 d, %d-01-01, stable", time.Now().Year()+1),
+		"GUI/UpdateCheckCount": "60",
+	}
+
+	for k, v := range extraData {
+		body := []byte(fmt.Sprintf(
+			`<IVirtualBox_setExtraData xmlns="http://www.virtualbox.org/"><_this>%s</_this><key>%s</key><value>%s</value></IVirtualBox_setExtraData>`,
+			xmlEscape(d.managedObjRef), xmlEscape(k), xmlEscape(v)))
+		if _, err := d.call("IVirtualBox_setExtraData", body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *VBoxWebDriver) LoadSnapshots(vmName string) (*VBoxSnapshot, error) {
+	machine, err := d.findMachine(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<IMachine_getSnapshotCount xmlns="http://www.virtualbox.org/"><_this>%s</_this></IMachine_getSnapshotCount>`,
+		xmlEscape(machine)))
+	resp, err := d.call("IMachine_getSnapshotCount", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var count struct {
+		Returnval int `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &count); err != nil {
+		return nil, err
+	}
+	if count.Returnval == 0 {
+		return nil, nil
+	}
+
+	rootBody := []byte(fmt.Sprintf(
+		`<IMachine_findSnapshot xmlns="http://www.virtualbox.org/"><_this>%s</_this><nameOrId></nameOrId></IMachine_findSnapshot>`,
+		xmlEscape(machine)))
+	rootResp, err := d.call("IMachine_findSnapshot", rootBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var root struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(rootResp, &root); err != nil {
+		return nil, err
+	}
+
+	return d.snapshotTree(root.Returnval)
+}
+
+// snapshotTree walks the ISnapshot tree starting at ref, building a
+// VBoxSnapshot from the actual objects instead of parsing the
+// "SnapshotName-N-N=..." lines VBoxManage emits.
+func (d *VBoxWebDriver) snapshotTree(ref string) (*VBoxSnapshot, error) {
+	nameBody := []byte(fmt.Sprintf(
+		`<ISnapshot_getName xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISnapshot_getName>`, xmlEscape(ref)))
+	nameResp, err := d.call("ISnapshot_getName", nameBody)
+	if err != nil {
+		return nil, err
+	}
+	var name struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(nameResp, &name); err != nil {
+		return nil, err
+	}
+
+	idBody := []byte(fmt.Sprintf(
+		`<ISnapshot_getId xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISnapshot_getId>`, xmlEscape(ref)))
+	idResp, err := d.call("ISnapshot_getId", idBody)
+	if err != nil {
+		return nil, err
+	}
+	var id struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(idResp, &id); err != nil {
+		return nil, err
+	}
+
+	childrenBody := []byte(fmt.Sprintf(
+		`<ISnapshot_getChildren xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISnapshot_getChildren>`, xmlEscape(ref)))
+	childrenResp, err := d.call("ISnapshot_getChildren", childrenBody)
+	if err != nil {
+		return nil, err
+	}
+	var children struct {
+		Returnval []string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(childrenResp, &children); err != nil {
+		return nil, err
+	}
+
+	node := &VBoxSnapshot{Name: name.Returnval, UUID: id.Returnval}
+	for _, childRef := range children.Returnval {
+		child, err := d.snapshotTree(childRef)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+	}
+
+	return node, nil
+}
+
+func (d *VBoxWebDriver) CreateSnapshot(vmname string, snapshotName string) error {
+	return d.withMachineSession(vmname, func(machine, session string) error {
+		consoleBody := []byte(fmt.Sprintf(
+			`<ISession_getConsole xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISession_getConsole>`, xmlEscape(session)))
+		consoleResp, err := d.call("ISession_getConsole", consoleBody)
+		if err != nil {
+			return err
+		}
+		var console struct {
+			Returnval string `xml:"returnval"`
+		}
+		if err := xml.Unmarshal(consoleResp, &console); err != nil {
+			return err
+		}
+
+		body := []byte(fmt.Sprintf(
+			`<IConsole_takeSnapshot xmlns="http://www.virtualbox.org/"><_this>%s</_this><name>%s</name><description></description></IConsole_takeSnapshot>`,
+			xmlEscape(console.Returnval), xmlEscape(snapshotName)))
+		_, err = d.call("IConsole_takeSnapshot", body)
+		return err
+	})
+}
+
+func (d *VBoxWebDriver) SetSnapshot(vmname string, sn *VBoxSnapshot) error {
+	return d.withMachineSession(vmname, func(machine, session string) error {
+		consoleRef, err := d.sessionConsole(session)
+		if err != nil {
+			return err
+		}
+
+		snapshotBody := []byte(fmt.Sprintf(
+			`<IMachine_findSnapshot xmlns="http://www.virtualbox.org/"><_this>%s</_this><nameOrId>%s</nameOrId></IMachine_findSnapshot>`,
+			xmlEscape(machine), xmlEscape(sn.UUID)))
+		snapshotResp, err := d.call("IMachine_findSnapshot", snapshotBody)
+		if err != nil {
+			return err
+		}
+		var snapshotRef struct {
+			Returnval string `xml:"returnval"`
+		}
+		if err := xml.Unmarshal(snapshotResp, &snapshotRef); err != nil {
+			return err
+		}
+
+		body := []byte(fmt.Sprintf(
+			`<IConsole_restoreSnapshot xmlns="http://www.virtualbox.org/"><_this>%s</_this><snapshot>%s</snapshot></IConsole_restoreSnapshot>`,
+			xmlEscape(consoleRef), xmlEscape(snapshotRef.Returnval)))
+		_, err = d.call("IConsole_restoreSnapshot", body)
+		return err
+	})
+}
+
+func (d *VBoxWebDriver) DeleteSnapshot(vmname string, sn *VBoxSnapshot) error {
+	return d.withMachineSession(vmname, func(machine, session string) error {
+		consoleRef, err := d.sessionConsole(session)
+		if err != nil {
+			return err
+		}
+
+		body := []byte(fmt.Sprintf(
+			`<IConsole_deleteSnapshot xmlns="http://www.virtualbox.org/"><_this>%s</_this><id>%s</id></IConsole_deleteSnapshot>`,
+			xmlEscape(consoleRef), xmlEscape(sn.UUID)))
+		_, err = d.call("IConsole_deleteSnapshot", body)
+		return err
+	})
+}
+
+func (d *VBoxWebDriver) sessionConsole(session string) (string, error) {
+	body := []byte(fmt.Sprintf(
+		`<ISession_getConsole xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISession_getConsole>`, xmlEscape(session)))
+	resp, err := d.call("ISession_getConsole", body)
+	if err != nil {
+		return "", err
+	}
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return "", err
+	}
+	return parsed.Returnval, nil
+}
+
+func (d *VBoxWebDriver) findMachine(name string) (string, error) {
+	if d.managedObjRef == "" {
+		if err := d.logon(); err != nil {
+			return "", err
+		}
+	}
+
+	body := []byte(fmt.Sprintf(
+		`<IVirtualBox_findMachine xmlns="http://www.virtualbox.org/"><_this>%s</_this><nameOrId>%s</nameOrId></IVirtualBox_findMachine>`,
+		xmlEscape(d.managedObjRef), xmlEscape(name)))
+	resp, err := d.call("IVirtualBox_findMachine", body)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(resp, &parsed); err != nil {
+		return "", err
+	}
+
+	return parsed.Returnval, nil
+}
+
+// withMachineSession opens a write-lock session on vmName, runs fn with
+// the machine and session object references, and unlocks the session
+// afterwards regardless of whether fn succeeded.
+func (d *VBoxWebDriver) withMachineSession(vmName string, fn func(machine, session string) error) error {
+	machine, err := d.findMachine(vmName)
+	if err != nil {
+		return err
+	}
+
+	sessionBody := []byte(fmt.Sprintf(
+		`<IWebsessionManager_getSessionObject xmlns="http://www.virtualbox.org/"><refIVirtualBox>%s</refIVirtualBox></IWebsessionManager_getSessionObject>`,
+		xmlEscape(d.managedObjRef)))
+	sessionResp, err := d.call("IWebsessionManager_getSessionObject", sessionBody)
+	if err != nil {
+		return err
+	}
+	var session struct {
+		Returnval string `xml:"returnval"`
+	}
+	if err := xml.Unmarshal(sessionResp, &session); err != nil {
+		return err
+	}
+
+	lockBody := []byte(fmt.Sprintf(
+		`<IMachine_lockMachine xmlns="http://www.virtualbox.org/"><_this>%s</_this><session>%s</session><lockType>Write</lockType></IMachine_lockMachine>`,
+		xmlEscape(machine), xmlEscape(session.Returnval)))
+	if _, err := d.call("IMachine_lockMachine", lockBody); err != nil {
+		return err
+	}
+	defer func() {
+		unlockBody := []byte(fmt.Sprintf(
+			`<ISession_unlockMachine xmlns="http://www.virtualbox.org/"><_this>%s</_this></ISession_unlockMachine>`,
+			xmlEscape(session.Returnval)))
+		if _, err := d.call("ISession_unlockMachine", unlockBody); err != nil {
+			log.Printf("unlocking session for %s: %s", vmName, err)
+		}
+	}()
+
+	return fn(machine, session.Returnval)
+}
+
+func (d *VBoxWebDriver) saveMachineSettings(machine string) error {
+	body := []byte(fmt.Sprintf(
+		`<IMachine_saveSettings xmlns="http://www.virtualbox.org/"><_this>%s</_this></IMachine_saveSettings>`,
+		xmlEscape(machine)))
+	_, err := d.call("IMachine_saveSettings", body)
+	return err
+}
+
+// SupportsPortCount reports whether CreateSATAController can use the
+// "--portcount"-equivalent addStorageController/setPortCount call,
+// available since VirtualBox 4.3.
+func (d *VBoxWebDriver) SupportsPortCount() bool {
+	return versionAtLeast(d.Version, "4.3")
+}
+
+// SupportsNestedVirt reports whether the connected VirtualBox can expose
+// nested virtualization to a guest, added in VirtualBox 6.0.
+func (d *VBoxWebDriver) SupportsNestedVirt() bool {
+	return versionAtLeast(d.Version, "6.0")
+}
+
+// SupportsParavirtProvider reports whether IMachine_setParavirtProvider
+// is available, added in VirtualBox 5.0.
+func (d *VBoxWebDriver) SupportsParavirtProvider() bool {
+	return versionAtLeast(d.Version, "5.0")
+}
+
+var _ Driver = new(VBoxWebDriver)
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestXMLEscape(t *testing.T) {
+	cases := map[string]string{
+		"plain":          "plain",
+		"a&b":            "a&amp;b",
+		`</username><x>`: "&lt;/username&gt;&lt;x&gt;",
+		`"quoted"`:       "&#34;quoted&#34;",
+	}
+	for in, want := range cases {
+		if got := xmlEscape(in); got != want {
+			t.Errorf("xmlEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// soapStub serves a single canned SOAP response body and records the
+// request body it received, so tests can both drive a driver method end
+// to end and inspect exactly what went out over the wire.
+func soapStub(t *testing.T, response string) (*httptest.Server, *string) {
+	t.Helper()
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotBody = string(b)
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &gotBody
+}
+
+func TestLogon_EscapesCredentialsAndCannotInjectMarkup(t *testing.T) {
+	srv, gotBody := soapStub(t, `<?xml version="1.0"?>`+
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">`+
+		`<SOAP-ENV:Body><IWebsessionManager_logonResponse><returnval>session-123</returnval></IWebsessionManager_logonResponse></SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+
+	d := &VBoxWebDriver{Endpoint: srv.URL, Username: `</username><admin>`, Password: "pw&pw"}
+	if err := d.logon(); err != nil {
+		t.Fatalf("logon: %s", err)
+	}
+	if d.managedObjRef != "session-123" {
+		t.Fatalf("managedObjRef = %q, want %q", d.managedObjRef, "session-123")
+	}
+
+	if strings.Contains(*gotBody, "<admin>") {
+		t.Fatalf("request body contains unescaped injected markup: %s", *gotBody)
+	}
+	if !strings.Contains(*gotBody, "&lt;/username&gt;&lt;admin&gt;") {
+		t.Fatalf("request body doesn't contain the escaped username: %s", *gotBody)
+	}
+}
+
+func TestCall_ReturnsFaultAsError(t *testing.T) {
+	srv, _ := soapStub(t, `<?xml version="1.0"?>`+
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">`+
+		`<SOAP-ENV:Body><SOAP-ENV:Fault><faultstring>failed</faultstring>`+
+		`<detail><VirtualBoxErrorInfo><resultCode>0x80004005</resultCode>`+
+		`<component>Appliance</component><text>bad input</text></VirtualBoxErrorInfo></detail>`+
+		`</SOAP-ENV:Fault></SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+
+	d := &VBoxWebDriver{Endpoint: srv.URL}
+	_, err := d.call("IAppliance_importMachines",
+		[]byte(`<IAppliance_importMachines xmlns="http://www.virtualbox.org/"></IAppliance_importMachines>`))
+	if err == nil {
+		t.Fatal("call: expected an error for a SOAP fault, got nil")
+	}
+	if !strings.Contains(err.Error(), "bad input") {
+		t.Fatalf("call error = %q, want it to mention the fault text", err)
+	}
+}
+
+func TestImportOptions(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags []string
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"single", []string{"--options", "keepallmacs"}, []string{"KeepAllMACs"}},
+		{"multiple comma separated", []string{"--options", "keepnatmacs,importtovdi"}, []string{"KeepNATMACs", "ImportToVDI"}},
+		{"unrecognized dropped", []string{"--options", "keepallmacs,bogus"}, []string{"KeepAllMACs"}},
+		{"dangling flag ignored", []string{"--options"}, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := importOptions(tt.flags)
+			if len(got) != len(tt.want) {
+				t.Fatalf("importOptions(%v) = %v, want %v", tt.flags, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("importOptions(%v) = %v, want %v", tt.flags, got, tt.want)
+				}
+			}
+		})
+	}
+}